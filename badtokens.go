@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// badToken records why a device token was permanently rejected by APNs, so
+// it can be surfaced to operators through the /feedback endpoint.
+type badToken struct {
+	Token     string    `json:"token"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// badTokenStore accumulates bad tokens in memory and, when a Redis address
+// is configured, mirrors them there so several proxy instances can share a
+// single feedback backlog.
+type badTokenStore struct {
+	mu     sync.Mutex
+	tokens []badToken
+	redis  *redis.Client
+}
+
+const (
+	badTokensRedisKey = "apns-proxy:bad-tokens"
+	redisOpTimeout    = 2 * time.Second
+)
+
+func newBadTokenStore() *badTokenStore {
+	s := &badTokenStore{}
+
+	if config.RedisAddr != "" {
+		s.redis = redis.NewClient(&redis.Options{Addr: config.RedisAddr})
+	}
+
+	return s
+}
+
+// add records token. When Redis is configured it is the sole backing store
+// so several proxy instances share one backlog; otherwise the token is kept
+// in memory.
+func (s *badTokenStore) add(token badToken) {
+	if s.redis != nil {
+		if data, err := json.Marshal(token); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+			defer cancel()
+			s.redis.RPush(ctx, badTokensRedisKey, data)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	s.tokens = append(s.tokens, token)
+	s.mu.Unlock()
+}
+
+// drain returns every bad token collected so far and clears the store.
+func (s *badTokenStore) drain() []badToken {
+	if s.redis == nil {
+		s.mu.Lock()
+		tokens := s.tokens
+		s.tokens = nil
+		s.mu.Unlock()
+		return tokens
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	entries, err := s.redis.LRange(ctx, badTokensRedisKey, 0, -1).Result()
+	if err != nil {
+		return nil
+	}
+	s.redis.Del(ctx, badTokensRedisKey)
+
+	tokens := make([]badToken, 0, len(entries))
+	for _, entry := range entries {
+		var token badToken
+		if err := json.Unmarshal([]byte(entry), &token); err == nil {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens
+}
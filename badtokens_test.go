@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBadTokenStoreAddAndDrainInMemory(t *testing.T) {
+	s := newBadTokenStore()
+
+	s.add(badToken{Token: "a", Reason: "Unregistered"})
+	s.add(badToken{Token: "b", Reason: "BadDeviceToken"})
+
+	got := s.drain()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 tokens, got %d", len(got))
+	}
+
+	if got := s.drain(); len(got) != 0 {
+		t.Fatalf("expected drain to clear the store, got %d leftover tokens", len(got))
+	}
+}
+
+func TestBadTokenStoreAddAndDrainRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	s := &badTokenStore{redis: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+
+	s.add(badToken{Token: "a", Reason: "Unregistered"})
+	s.add(badToken{Token: "b", Reason: "BadDeviceToken"})
+
+	got := s.drain()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 tokens, got %d", len(got))
+	}
+
+	// A token recorded by this instance must not resurface after another
+	// instance (or this one) has already drained and cleared the shared list.
+	if got := s.drain(); len(got) != 0 {
+		t.Fatalf("expected drain to clear the shared Redis backlog, got %d leftover tokens", len(got))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sideshow/apns2"
+)
+
+// batchRequest is the body accepted by POST /3/devices.
+type batchRequest struct {
+	Tokens  []string          `json:"tokens"`
+	Headers map[string]string `json:"headers"`
+	Payload json.RawMessage   `json:"payload"`
+}
+
+// batchResult is the outcome of pushing to a single token within a batch.
+type batchResult struct {
+	Token  string `json:"token"`
+	Status int    `json:"status"`
+	ApnsID string `json:"apns_id"`
+	Reason string `json:"reason"`
+}
+
+// batchPushHandler fans a single payload out to many device tokens
+// concurrently, bounded by config.BatchConcurrency, and aggregates the
+// per-token results.
+func batchPushHandler(registry *clientRegistry, badTokens *badTokenStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if len(req.Tokens) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("tokens must not be empty"))
+			return
+		}
+
+		topic := req.Headers["apns-topic"]
+		apnsClient := registry.get(topic)
+		if apnsClient == nil {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("no APNs client configured for this topic"))
+			return
+		}
+
+		priority, _ := strconv.Atoi(req.Headers["apns-priority"])
+		expiration, _ := strconv.Atoi(req.Headers["apns-expiration"])
+		pushType := apns2.EPushType(req.Headers["apns-push-type"])
+		collapseID := req.Headers["apns-collapse-id"]
+
+		results := make([]batchResult, len(req.Tokens))
+		sem := make(chan struct{}, config.BatchConcurrency)
+		var wg sync.WaitGroup
+
+		for i, deviceToken := range req.Tokens {
+			wg.Add(1)
+			sem <- struct{}{}
+
+			go func(i int, deviceToken string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				results[i] = pushOne(apnsClient, apns2.Notification{
+					ApnsID:      uuid.New().String(),
+					CollapseID:  collapseID,
+					DeviceToken: deviceToken,
+					Expiration:  time.Unix(int64(expiration), 0),
+					Payload:     req.Payload,
+					Priority:    priority,
+					PushType:    pushType,
+					Topic:       topic,
+				}, badTokens)
+			}(i, deviceToken)
+		}
+
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]batchResult{"results": results})
+	}
+}
+
+// pushOne performs one synchronous push, recording metrics, structured logs
+// and bad tokens the same way the single-device and worker pool paths do.
+func pushOne(apnsClient *apns2.Client, notification apns2.Notification, badTokens *badTokenStore) batchResult {
+	start := time.Now()
+	response, err := apnsClient.Push(&notification)
+	duration := time.Since(start)
+
+	recordOutcome(notification.Topic, notification, response, err, duration, badTokens)
+
+	if err != nil {
+		return batchResult{Token: notification.DeviceToken, Status: http.StatusBadGateway, Reason: err.Error()}
+	}
+
+	return batchResult{
+		Token:  notification.DeviceToken,
+		Status: response.StatusCode,
+		ApnsID: response.ApnsID,
+		Reason: response.Reason,
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/token"
+)
+
+// clientRegistry holds the APNs clients for every configured app, keyed by
+// topic, and resolves the client to use for a given `apns-topic` header.
+type clientRegistry struct {
+	mu           sync.RWMutex
+	clients      map[string]*apns2.Client
+	defaultTopic string
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{}
+}
+
+// load (re)builds the registry from the current configuration. It is safe
+// to call while the proxy is serving requests, allowing hot-reload on
+// SIGHUP.
+func (r *clientRegistry) load() error {
+	clients := make(map[string]*apns2.Client)
+	defaultTopic := ""
+
+	if len(config.Apps) == 0 {
+		// Backward compatible single-app configuration: the top level
+		// cert/auth-key flags describe the one and only app.
+		client, err := createAPNSClient(AppConfig{
+			CertFile:    config.CertFile,
+			KeyFile:     config.KeyFile,
+			AuthKeyFile: config.AuthKeyFile,
+			KeyID:       config.KeyID,
+			TeamID:      config.TeamID,
+			Development: config.Development,
+		})
+		if err != nil {
+			return err
+		}
+		clients[""] = client
+	} else {
+		for _, app := range config.Apps {
+			client, err := createAPNSClient(app)
+			if err != nil {
+				return fmt.Errorf("unable to create APNs client for app %q: %s", app.Name, err)
+			}
+
+			clients[app.Topic] = client
+
+			if app.Default || defaultTopic == "" {
+				defaultTopic = app.Topic
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.clients = clients
+	r.defaultTopic = defaultTopic
+	r.mu.Unlock()
+
+	return nil
+}
+
+// get returns the client registered for topic, falling back to the
+// configured default app when topic is empty or unknown.
+func (r *clientRegistry) get(topic string) *apns2.Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if client, ok := r.clients[topic]; ok {
+		return client
+	}
+
+	return r.clients[r.defaultTopic]
+}
+
+// watchReload reloads the configuration file and rebuilds the client
+// registry whenever the process receives SIGHUP, so apps can be added or
+// removed without restarting the proxy.
+func (r *clientRegistry) watchReload() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			log.Printf("Received SIGHUP, reloading configuration")
+
+			if config.Filename != "" {
+				if err := loadConfiguration(); err != nil {
+					log.Printf("Failed to reload configuration: %s", err)
+					continue
+				}
+			}
+
+			if err := r.load(); err != nil {
+				log.Printf("Failed to reload APNs clients: %s", err)
+			}
+		}
+	}()
+}
+
+func createAPNSClient(app AppConfig) (client *apns2.Client, err error) {
+	if app.AuthKeyFile != "" {
+		fmt.Printf("Using auth key %s\n", app.AuthKeyFile)
+
+		authKey, err := token.AuthKeyFromFile(app.AuthKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		client = apns2.NewTokenClient(&token.Token{
+			AuthKey: authKey,
+			KeyID:   app.KeyID,
+			TeamID:  app.TeamID,
+		})
+	} else {
+		var cert tls.Certificate
+
+		if app.CertFile != "" && app.KeyFile != "" {
+			fmt.Printf("Using certificates %s and %s\n", app.CertFile, app.KeyFile)
+			if cert, err = tls.LoadX509KeyPair(app.CertFile, app.KeyFile); err != nil {
+				return nil, err
+			}
+		}
+
+		client = apns2.NewClient(cert)
+	}
+
+	httpClient, err := upstreamProxyHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	if httpClient != nil {
+		fmt.Printf("Routing APNs traffic through upstream proxy %s\n", config.UpstreamProxy)
+		client.HTTPClient = httpClient
+	}
+
+	if app.Development {
+		log.Printf("Using development mode\n")
+		return client.Development(), nil
+	}
+
+	return client.Production(), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// AppConfig describes a single APNs application: its credentials, default
+// topic and environment. Configuring multiple apps lets the proxy route
+// pushes for several bundle IDs through a single instance.
+type AppConfig struct {
+	Name        string `mapstructure:"name"`
+	Topic       string `mapstructure:"topic"`
+	Default     bool   `mapstructure:"default"`
+	Development bool   `mapstructure:"development"`
+	CertFile    string `mapstructure:"cert"`
+	KeyFile     string `mapstructure:"key"`
+	AuthKeyFile string `mapstructure:"auth_key"`
+	KeyID       string `mapstructure:"key_id"`
+	TeamID      string `mapstructure:"team_id"`
+}
+
+var config struct {
+	Development bool
+	Verbose     bool
+	Filename    string
+	Listen      string      `mapstructure:"listen"`
+	CertFile    string      `mapstructure:"cert"`
+	KeyFile     string      `mapstructure:"key"`
+	AuthKeyFile string      `mapstructure:"auth_key"`
+	KeyID       string      `mapstructure:"key_id"`
+	TeamID      string      `mapstructure:"team_id"`
+	Apps        []AppConfig `mapstructure:"apps"`
+
+	UpstreamProxy         string `mapstructure:"upstream_proxy"`
+	UpstreamProxyUser     string `mapstructure:"upstream_proxy_user"`
+	UpstreamProxyPassword string `mapstructure:"upstream_proxy_password"`
+
+	Workers   int    `mapstructure:"workers"`
+	QueueSize int    `mapstructure:"queue_size"`
+	MaxRetry  int    `mapstructure:"max_retry"`
+	RedisAddr string `mapstructure:"redis_addr"`
+
+	LogFormat string `mapstructure:"log_format"`
+	LogLevel  string `mapstructure:"log_level"`
+
+	BatchConcurrency int `mapstructure:"batch_concurrency"`
+}
+
+func loadConfiguration() error {
+	viper.SetConfigType("yaml")
+
+	configFile, err := os.Open(config.Filename)
+	if err != nil {
+		return err
+	}
+	defer configFile.Close()
+
+	if err := viper.ReadConfig(configFile); err != nil {
+		return err
+	}
+
+	err = viper.Unmarshal(&config)
+	if err != nil {
+		return fmt.Errorf("unable to load configuration: %s", err)
+	}
+
+	return nil
+}
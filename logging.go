@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pushLogger emits one structured JSON or text line per push attempt.
+var pushLogger = logrus.New()
+
+func setupLogger() {
+	if config.LogFormat == "json" {
+		pushLogger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		pushLogger.SetFormatter(&logrus.TextFormatter{})
+	}
+
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	pushLogger.SetLevel(level)
+	pushLogger.SetOutput(os.Stdout)
+}
+
+func logPush(apnsID, device, topic, pushType, reason string, status int, duration time.Duration) {
+	pushLogger.WithFields(logrus.Fields{
+		"apns_id":     apnsID,
+		"device":      device,
+		"topic":       topic,
+		"push_type":   pushType,
+		"status":      status,
+		"reason":      reason,
+		"duration_ms": duration.Milliseconds(),
+	}).Info("push")
+}
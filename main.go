@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -13,20 +12,13 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sideshow/apns2"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var config struct {
-	Development bool
-	Verbose     bool
-	Filename    string
-	Listen      string `mapstructure:"listen"`
-	CertFile    string `mapstructure:"cert"`
-	KeyFile     string `mapstructure:"key"`
-}
-
 var rootCmd = &cobra.Command{
 	Use:   "apns-proxy",
 	Short: "apns-proxy is a proxy for Apple Push Notification Service",
@@ -38,55 +30,23 @@ var rootCmd = &cobra.Command{
 			}
 		}
 
+		setupLogger()
+
 		if err := listenAndServer(); err != nil {
 			log.Fatal(err)
 		}
 	},
 }
 
-func loadConfiguration() error {
-	viper.SetConfigType("yaml")
-
-	configFile, err := os.Open(config.Filename)
-	if err != nil {
-		return err
-	}
-
-	if err := viper.ReadConfig(configFile); err != nil {
-		return err
-	}
-
-	err = viper.Unmarshal(&config)
-	if err != nil {
-		return fmt.Errorf("unable to load configuration: %s", err)
-	}
-
-	return nil
-}
-
-func createAPNSClient() (client *apns2.Client, err error) {
-	var cert tls.Certificate
-
-	if config.CertFile != "" && config.KeyFile != "" {
-		fmt.Printf("Using certificates %s and %s\n", config.CertFile, config.KeyFile)
-		if cert, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile); err != nil {
-			return nil, err
-		}
-	}
-
-	if config.Development {
-		log.Printf("Using development mode\n")
-		return apns2.NewClient(cert).Development(), nil
-	}
-
-	return apns2.NewClient(cert).Production(), nil
-}
-
 func listenAndServer() error {
-	apnsClient, err := createAPNSClient()
-	if err != nil {
+	registry := newClientRegistry()
+	if err := registry.load(); err != nil {
 		return err
 	}
+	registry.watchReload()
+
+	badTokens := newBadTokenStore()
+	pool := newWorkerPool(config.Workers, config.QueueSize, badTokens)
 
 	r := chi.NewRouter()
 	r.Post("/3/device/{device}", func(w http.ResponseWriter, r *http.Request) {
@@ -99,36 +59,66 @@ func listenAndServer() error {
 		}
 		priority, _ := strconv.Atoi(r.Header.Get("apns-priority"))
 		expiration, _ := strconv.Atoi(r.Header.Get("apns-expiration"))
+		topic := r.Header.Get("apns-topic")
+
+		apnsID := r.Header.Get("apns-id")
+		if apnsID == "" {
+			apnsID = uuid.New().String()
+		}
 
 		notification := apns2.Notification{
-			ApnsID:      r.Header.Get("apns-id"),
+			ApnsID:      apnsID,
 			CollapseID:  r.Header.Get("apns-collapse-id"),
 			DeviceToken: device,
 			Expiration:  time.Unix(int64(expiration), 0),
 			Payload:     json.RawMessage(body),
 			Priority:    priority,
 			PushType:    apns2.EPushType(r.Header.Get("apns-push-type")),
-			Topic:       r.Header.Get("apns-topic"),
+			Topic:       topic,
 		}
 
-		response, err := apnsClient.Push(&notification)
-		if err != nil {
-			if config.Verbose {
-				log.Printf("Failed to send push: %s", err)
-			}
+		apnsClient := registry.get(topic)
+		if apnsClient == nil {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("no APNs client configured for this topic"))
 			return
 		}
 
-		if config.Verbose {
-			log.Printf("Push sent. APNS ID %s, Status: %d, reason: %s", response.ApnsID, response.StatusCode, response.Reason)
+		if r.URL.Query().Get("sync") == "true" {
+			result := pushOne(apnsClient, notification, badTokens)
+			w.WriteHeader(result.Status)
+			w.Write([]byte(result.Reason))
+			return
 		}
 
-		w.WriteHeader(response.StatusCode)
-		w.Write([]byte(response.Reason))
+		if !pool.submit(pushJob{client: apnsClient, notification: notification}) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("push queue is full"))
+			return
+		}
+
+		w.Header().Set("apns-id", apnsID)
+		w.WriteHeader(http.StatusAccepted)
 	})
 
+	r.Post("/3/devices", batchPushHandler(registry, badTokens))
+
+	r.Get("/feedback", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(badTokens.drain())
+	})
+
+	r.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:         config.Listen,
+		Handler:      r,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
 	log.Printf("Listening on %s", config.Listen)
-	return http.ListenAndServe(config.Listen, r)
+	return server.ListenAndServe()
 }
 
 func main() {
@@ -150,6 +140,45 @@ func main() {
 	rootCmd.Flags().StringVar(&config.KeyFile, "key", "", "Certificate private key")
 	viper.BindPFlag("key", rootCmd.Flags().Lookup("key"))
 
+	rootCmd.Flags().StringVar(&config.AuthKeyFile, "auth-key", "", "Apple APNs auth key (.p8) for token-based authentication")
+	viper.BindPFlag("auth_key", rootCmd.Flags().Lookup("auth-key"))
+
+	rootCmd.Flags().StringVar(&config.KeyID, "key-id", "", "Key ID matching the APNs auth key")
+	viper.BindPFlag("key_id", rootCmd.Flags().Lookup("key-id"))
+
+	rootCmd.Flags().StringVar(&config.TeamID, "team-id", "", "Apple Developer Team ID matching the APNs auth key")
+	viper.BindPFlag("team_id", rootCmd.Flags().Lookup("team-id"))
+
+	rootCmd.Flags().StringVar(&config.UpstreamProxy, "upstream-proxy", "", "HTTP/HTTPS proxy to use when connecting to APNs, e.g. http://proxy.example.com:8080")
+	viper.BindPFlag("upstream_proxy", rootCmd.Flags().Lookup("upstream-proxy"))
+
+	rootCmd.Flags().StringVar(&config.UpstreamProxyUser, "upstream-proxy-user", "", "Username for upstream proxy basic authentication")
+	viper.BindPFlag("upstream_proxy_user", rootCmd.Flags().Lookup("upstream-proxy-user"))
+
+	rootCmd.Flags().StringVar(&config.UpstreamProxyPassword, "upstream-proxy-password", "", "Password for upstream proxy basic authentication")
+	viper.BindPFlag("upstream_proxy_password", rootCmd.Flags().Lookup("upstream-proxy-password"))
+
+	rootCmd.Flags().IntVar(&config.Workers, "workers", 4, "Number of background workers delivering queued pushes")
+	viper.BindPFlag("workers", rootCmd.Flags().Lookup("workers"))
+
+	rootCmd.Flags().IntVar(&config.QueueSize, "queue-size", 100, "Size of the background push delivery queue")
+	viper.BindPFlag("queue_size", rootCmd.Flags().Lookup("queue-size"))
+
+	rootCmd.Flags().IntVar(&config.MaxRetry, "max-retry", 5, "Maximum number of retries on transient APNs failures")
+	viper.BindPFlag("max_retry", rootCmd.Flags().Lookup("max-retry"))
+
+	rootCmd.Flags().StringVar(&config.RedisAddr, "redis-addr", "", "Redis address used to share the bad tokens feedback store across instances")
+	viper.BindPFlag("redis_addr", rootCmd.Flags().Lookup("redis-addr"))
+
+	rootCmd.Flags().StringVar(&config.LogFormat, "log-format", "text", "Push log format: text or json")
+	viper.BindPFlag("log_format", rootCmd.Flags().Lookup("log-format"))
+
+	rootCmd.Flags().StringVar(&config.LogLevel, "log-level", "info", "Push log level (debug, info, warn, error)")
+	viper.BindPFlag("log_level", rootCmd.Flags().Lookup("log-level"))
+
+	rootCmd.Flags().IntVar(&config.BatchConcurrency, "batch-concurrency", 20, "Maximum number of concurrent pushes for a single /3/devices batch request")
+	viper.BindPFlag("batch_concurrency", rootCmd.Flags().Lookup("batch-concurrency"))
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(strings.Title(err.Error()))
 		os.Exit(1)
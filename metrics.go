@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pushesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "apns_proxy_pushes_total",
+		Help: "Total number of pushes successfully delivered to APNs, by topic.",
+	}, []string{"topic"})
+
+	responsesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "apns_proxy_responses_total",
+		Help: "Total number of APNs responses, by topic and status code.",
+	}, []string{"topic", "status"})
+
+	pushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "apns_proxy_push_duration_seconds",
+		Help: "Time spent waiting for an APNs response, by topic.",
+	}, []string{"topic"})
+
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "apns_proxy_retries_total",
+		Help: "Total number of push retries, by topic.",
+	}, []string{"topic"})
+
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "apns_proxy_queue_depth",
+		Help: "Number of pushes currently waiting in the delivery queue.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(pushesTotal, responsesTotal, pushDuration, retriesTotal, queueDepth)
+}
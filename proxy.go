@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/sideshow/apns2"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/http2"
+)
+
+// upstreamProxyHTTPClient builds the *http.Client an apns2.Client should use
+// to reach Apple through the configured upstream HTTP/HTTPS proxy, or nil if
+// no upstream proxy is configured.
+//
+// NO_PROXY is honoured the same way the standard library does, so the proxy
+// can itself be bypassed for some destinations in restricted networks.
+func upstreamProxyHTTPClient() (*http.Client, error) {
+	if config.UpstreamProxy == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(config.UpstreamProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy URL: %s", err)
+	}
+
+	if config.UpstreamProxyUser != "" {
+		proxyURL.User = url.UserPassword(config.UpstreamProxyUser, config.UpstreamProxyPassword)
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxyURL.String(),
+		HTTPSProxy: proxyURL.String(),
+		NoProxy:    os.Getenv("NO_PROXY"),
+	}).ProxyFunc()
+
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		},
+	}
+
+	if err := http2.ConfigureTransport(transport); err != nil {
+		return nil, fmt.Errorf("unable to configure HTTP/2 over the upstream proxy: %s", err)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   apns2.HTTPClientTimeout,
+	}, nil
+}
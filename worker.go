@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sideshow/apns2"
+)
+
+// pushJob is one notification queued for asynchronous delivery.
+type pushJob struct {
+	client       *apns2.Client
+	notification apns2.Notification
+	attempt      int
+}
+
+// workerPool delivers queued notifications in the background, retrying
+// transient failures with exponential backoff and recording permanently
+// rejected tokens in badTokens.
+type workerPool struct {
+	jobs      chan pushJob
+	badTokens *badTokenStore
+}
+
+func newWorkerPool(workers, queueSize int, badTokens *badTokenStore) *workerPool {
+	p := &workerPool{
+		jobs:      make(chan pushJob, queueSize),
+		badTokens: badTokens,
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+
+	return p
+}
+
+// submit enqueues job without blocking. It reports false when the queue is
+// full so callers (the HTTP handler) can fail fast instead of stalling.
+func (p *workerPool) submit(job pushJob) bool {
+	select {
+	case p.jobs <- job:
+		queueDepth.Set(float64(len(p.jobs)))
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *workerPool) run() {
+	for job := range p.jobs {
+		queueDepth.Set(float64(len(p.jobs)))
+		p.process(job)
+	}
+}
+
+func (p *workerPool) process(job pushJob) {
+	topic := job.notification.Topic
+	start := time.Now()
+	response, err := job.client.Push(&job.notification)
+	duration := time.Since(start)
+
+	recordOutcome(topic, job.notification, response, err, duration, p.badTokens)
+
+	if err != nil || (response != nil && response.StatusCode >= http.StatusInternalServerError) {
+		retriesTotal.WithLabelValues(topic).Inc()
+		p.retry(job, err)
+	}
+}
+
+// recordOutcome logs and records metrics for one completed push attempt, and
+// flags the device token as bad when APNs permanently rejected it. response
+// may be nil when the push failed before APNs replied (e.g. a connection
+// error); this is shared by the worker pool and the sync/batch push paths so
+// the two don't drift out of sync.
+func recordOutcome(topic string, notification apns2.Notification, response *apns2.Response, err error, duration time.Duration, badTokens *badTokenStore) {
+	status := 0
+	reason := ""
+	if response != nil {
+		status = response.StatusCode
+		reason = response.Reason
+	}
+	if err != nil {
+		reason = err.Error()
+	}
+	logPush(notification.ApnsID, notification.DeviceToken, topic, string(notification.PushType), reason, status, duration)
+
+	if response == nil {
+		return
+	}
+
+	if response.Sent() {
+		pushesTotal.WithLabelValues(topic).Inc()
+	}
+	responsesTotal.WithLabelValues(topic, strconv.Itoa(response.StatusCode)).Inc()
+	pushDuration.WithLabelValues(topic).Observe(duration.Seconds())
+
+	switch response.Reason {
+	case apns2.ReasonBadDeviceToken, apns2.ReasonUnregistered:
+		badTokens.add(badToken{
+			Token:     notification.DeviceToken,
+			Reason:    response.Reason,
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+func (p *workerPool) retry(job pushJob, err error) {
+	if job.attempt >= config.MaxRetry {
+		if config.Verbose {
+			log.Printf("Giving up on push to %s after %d attempts: %v", job.notification.DeviceToken, job.attempt+1, err)
+		}
+		return
+	}
+
+	job.attempt++
+	delay := backoff(job.attempt)
+
+	if config.Verbose {
+		log.Printf("Retrying push to %s in %s (attempt %d): %v", job.notification.DeviceToken, delay, job.attempt, err)
+	}
+
+	time.AfterFunc(delay, func() {
+		if !p.submit(job) {
+			log.Printf("Dropping push to %s: retry queue is full", job.notification.DeviceToken)
+		}
+	})
+}
+
+// backoff returns the delay before retry number attempt, doubling each time
+// and capped at 30 seconds.
+func backoff(attempt int) time.Duration {
+	delay := time.Second << uint(attempt-1)
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	return delay
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, 30 * time.Second}, // capped
+	}
+
+	for _, c := range cases {
+		if got := backoff(c.attempt); got != c.want {
+			t.Errorf("backoff(%d) = %s, want %s", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestWorkerPoolSubmitRejectsWhenFull(t *testing.T) {
+	p := &workerPool{jobs: make(chan pushJob, 1)}
+
+	if !p.submit(pushJob{}) {
+		t.Fatal("expected first submit to succeed")
+	}
+	if p.submit(pushJob{}) {
+		t.Fatal("expected submit to fail once the queue is full")
+	}
+
+	<-p.jobs
+
+	if !p.submit(pushJob{}) {
+		t.Fatal("expected submit to succeed again once a slot freed up")
+	}
+}
+
+func TestWorkerPoolRetryGivesUpAfterMaxRetry(t *testing.T) {
+	config.MaxRetry = 0
+	p := &workerPool{jobs: make(chan pushJob, 1)}
+
+	p.retry(pushJob{attempt: 0}, nil)
+
+	select {
+	case <-p.jobs:
+		t.Fatal("job should not have been resubmitted once max retries was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWorkerPoolRetryResubmitsWithIncrementedAttempt(t *testing.T) {
+	config.MaxRetry = 3
+	p := &workerPool{jobs: make(chan pushJob, 1)}
+
+	p.retry(pushJob{attempt: 0}, nil)
+
+	select {
+	case job := <-p.jobs:
+		if job.attempt != 1 {
+			t.Fatalf("expected attempt to be incremented to 1, got %d", job.attempt)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected job to be resubmitted after the backoff delay")
+	}
+}